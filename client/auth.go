@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tokenMetadataKey is the gRPC metadata key carrying the bearer token on
+// every Pipeline/PipelineUDP call.
+const tokenMetadataKey = "authorization"
+
+// CredentialProvider supplies the bearer token sent with every call.
+// Implementations may return a static value or fetch/rotate it on each call.
+type CredentialProvider interface {
+	Token() (string, error)
+}
+
+// StaticTokenProvider returns the same token on every call.
+type StaticTokenProvider string
+
+func (p StaticTokenProvider) Token() (string, error) {
+	return string(p), nil
+}
+
+// FileTokenProvider re-reads the token from disk on every call, so an
+// operator can rotate it by rewriting the file without restarting the
+// client.
+type FileTokenProvider struct {
+	Path string
+}
+
+func (p FileTokenProvider) Token() (string, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ExecTokenProvider runs an external command and uses its trimmed stdout as
+// the token, for operators who mint tokens via an external credential
+// helper (e.g. a cloud IAM exec-plugin).
+type ExecTokenProvider struct {
+	Command string
+	Args    []string
+}
+
+func (p ExecTokenProvider) Token() (string, error) {
+	out, err := exec.Command(p.Command, p.Args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("exec token provider: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// credentialProvider supplies the per-call auth token. Leaving it nil
+// disables token auth (e.g. when relying on mTLS alone).
+var credentialProvider CredentialProvider
+
+func withToken(ctx context.Context) (context.Context, error) {
+	if credentialProvider == nil {
+		return ctx, nil
+	}
+	token, err := credentialProvider.Token()
+	if err != nil {
+		return nil, fmt.Errorf("credential provider: %w", err)
+	}
+	return metadata.AppendToOutgoingContext(ctx, tokenMetadataKey, "Bearer "+token), nil
+}
+
+func authUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, err := withToken(ctx)
+	if err != nil {
+		return err
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func authStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, err := withToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
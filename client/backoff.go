@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"grpc-socks/pb"
+)
+
+// BackoffConfig controls the retry loop used when opening a Pipeline
+// stream against a backend that's transiently unavailable, so operators
+// can tune it without touching call sites.
+type BackoffConfig struct {
+	Base       time.Duration
+	Max        time.Duration
+	MaxElapsed time.Duration
+}
+
+var backoffConfig = BackoffConfig{
+	Base:       time.Second,
+	Max:        2 * time.Minute,
+	MaxElapsed: 5 * time.Minute,
+}
+
+func retryableStreamErr(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoffDelay returns the delay before retry attempt n (0-indexed),
+// doubling from cfg.Base up to cfg.Max, then jittered to a random value
+// between half and all of that.
+func backoffDelay(n int, cfg BackoffConfig) time.Duration {
+	d := cfg.Base
+	for i := 0; i < n; i++ {
+		d *= 2
+		if d >= cfg.Max {
+			d = cfg.Max
+			break
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// openPipelineWithRetry opens a Pipeline stream, retrying with exponential
+// backoff while the failure looks transient (Unavailable/DeadlineExceeded)
+// and giving up immediately on anything else (e.g. Unauthenticated,
+// PermissionDenied) or once MaxElapsed has passed.
+func openPipelineWithRetry(ctx context.Context, client pb.SocksClient) (pb.Socks_PipelineClient, error) {
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		stream, err := client.Pipeline(ctx, callOptions...)
+		if err == nil {
+			return stream, nil
+		}
+		if !retryableStreamErr(err) || time.Since(start) >= backoffConfig.MaxElapsed {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoffDelay(attempt, backoffConfig)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoffDelayBounds(t *testing.T) {
+	cfg := BackoffConfig{Base: time.Second, Max: 8 * time.Second}
+
+	cases := []struct {
+		n                int
+		wantMin, wantMax time.Duration
+	}{
+		{0, 500 * time.Millisecond, time.Second},
+		{1, time.Second, 2 * time.Second},
+		{2, 2 * time.Second, 4 * time.Second},
+		{10, 4 * time.Second, 8 * time.Second}, // capped at cfg.Max
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			d := backoffDelay(c.n, cfg)
+			if d < c.wantMin || d > c.wantMax {
+				t.Fatalf("backoffDelay(%d) = %s, want [%s, %s]", c.n, d, c.wantMin, c.wantMax)
+			}
+		}
+	}
+}
+
+func TestRetryableStreamErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unavailable", status.Error(codes.Unavailable, "down"), true},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "slow"), true},
+		{"unauthenticated", status.Error(codes.Unauthenticated, "nope"), false},
+		{"permission denied", status.Error(codes.PermissionDenied, "nope"), false},
+		{"plain error", errors.New("boom"), false},
+	}
+
+	for _, c := range cases {
+		if got := retryableStreamErr(c.err); got != c.want {
+			t.Errorf("retryableStreamErr(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
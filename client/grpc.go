@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	"grpc-socks/metrics"
+	"grpc-socks/pb"
+)
+
+// serverAddr is the remote proxy's gRPC listen address.
+var serverAddr = "127.0.0.1:9000"
+
+// keepaliveParams pings the proxy on an idle stream so a path that died
+// silently behind a NAT/firewall surfaces as an Unavailable error instead of
+// wedging the tunnel forever.
+var keepaliveParams = keepalive.ClientParameters{
+	Time:                20 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+func dialOptions() ([]grpc.DialOption, error) {
+	opts := []grpc.DialOption{
+		grpc.WithKeepaliveParams(keepaliveParams),
+	}
+
+	creds, err := transportCredentials(tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("build transport credentials: %w", err)
+	}
+	if creds != nil {
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(authUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(authStreamInterceptor),
+		grpc.WithStatsHandler(metrics.Handler{}),
+	)
+
+	return opts, nil
+}
+
+func gRPCClient() (pb.SocksClient, error) {
+	opts, err := dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(serverAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewSocksClient(conn), nil
+}
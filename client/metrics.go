@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"grpc-socks/log"
+)
+
+// metricsAddr is the listen address for the /metrics endpoint, a sibling
+// to the socks5 listener.
+var metricsAddr = ":9091"
+
+// ServeMetrics starts the Prometheus /metrics HTTP endpoint. It blocks, so
+// callers should run it in its own goroutine alongside the socks5 listener.
+func ServeMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Errorf("metrics server exited: %s", http.ListenAndServe(metricsAddr, mux))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	"grpc-socks/lib"
+	"grpc-socks/log"
+	"grpc-socks/pb"
+)
+
+// udpIdleTimeout bounds how long an idle UDP association is kept open: it
+// drives each destination's own idle timer, and the relay socket's read
+// deadline, which udpHandler re-arms on every read instead of setting once.
+const udpIdleTimeout = 600 * time.Second
+
+// udpAssociation is one (client bind addr, destination) pair: its own
+// PipelineUDP stream, demuxed independently of every other association
+// sharing the client's relay socket.
+type udpAssociation struct {
+	key        string
+	clientAddr net.Addr
+	dstAddr    string
+	stream     pb.Socks_PipelineUDPClient
+	cancel     context.CancelFunc
+
+	mu        sync.Mutex
+	sentFirst bool
+	timer     *time.Timer
+}
+
+// udpNATTable demultiplexes a single client-facing relay socket across
+// however many concurrent UDP associations are active, keyed by
+// (clientAddr, dstAddr) instead of the single shared natTableInfo the
+// previous implementation kept.
+type udpNATTable struct {
+	mu      sync.Mutex
+	entries map[string]*udpAssociation
+}
+
+func newUDPNATTable() *udpNATTable {
+	return &udpNATTable{entries: make(map[string]*udpAssociation)}
+}
+
+func natKey(clientAddr net.Addr, dstAddr string) string {
+	return clientAddr.String() + "->" + dstAddr
+}
+
+// get returns the association for (clientAddr, dstAddr), opening a new
+// PipelineUDP stream and demux goroutine the first time that pair is seen.
+// Two packets racing to the same new pair both dial before either is
+// visible in the table, so the map insert below is a compare-and-swap: the
+// loser tears down its own stream instead of clobbering the winner's.
+func (t *udpNATTable) get(clientAddr net.Addr, dstAddr string, udpLn net.PacketConn, username string) (*udpAssociation, error) {
+	key := natKey(clientAddr, dstAddr)
+
+	t.mu.Lock()
+	a, ok := t.entries[key]
+	t.mu.Unlock()
+	if ok {
+		a.resetIdle(t)
+		return a, nil
+	}
+
+	client, err := gRPCClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, socksUserMetadataKey, username)
+	}
+
+	stream, err := client.PipelineUDP(ctx, callOptions...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	candidate := &udpAssociation{
+		key:        key,
+		clientAddr: clientAddr,
+		dstAddr:    dstAddr,
+		stream:     stream,
+		cancel:     cancel,
+	}
+
+	t.mu.Lock()
+	if existing, ok := t.entries[key]; ok {
+		t.mu.Unlock()
+		// Lost the race to another goroutine opening the same pair; drop
+		// our duplicate stream and use the winner's.
+		cancel()
+		stream.CloseSend()
+		existing.resetIdle(t)
+		return existing, nil
+	}
+	t.entries[key] = candidate
+	t.mu.Unlock()
+
+	candidate.resetIdle(t)
+	go candidate.demux(t, udpLn)
+
+	return candidate, nil
+}
+
+func (a *udpAssociation) resetIdle(t *udpNATTable) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(udpIdleTimeout, func() {
+		t.remove(a)
+	})
+}
+
+// markFirstFrameSent reports whether the caller should send dstAddr as the
+// PipelineUDP stream's opening frame, marking it sent exactly once.
+func (a *udpAssociation) markFirstFrameSent() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sentFirst {
+		return false
+	}
+	a.sentFirst = true
+	return true
+}
+
+// remove tears down a's stream and, if a is still the table's current
+// occupant of its key (it may have already been replaced or removed by
+// another goroutine), drops it from the map.
+func (t *udpNATTable) remove(a *udpAssociation) {
+	t.mu.Lock()
+	if t.entries[a.key] == a {
+		delete(t.entries, a.key)
+	}
+	t.mu.Unlock()
+
+	a.cancel()
+	a.stream.CloseSend()
+}
+
+func (t *udpNATTable) closeAll() {
+	t.mu.Lock()
+	entries := t.entries
+	t.entries = make(map[string]*udpAssociation)
+	t.mu.Unlock()
+
+	for _, a := range entries {
+		a.mu.Lock()
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+		a.mu.Unlock()
+		a.cancel()
+		a.stream.CloseSend()
+	}
+}
+
+// demux reads responses off the association's stream and writes them back
+// to the client's relay socket at clientAddr, so replies for concurrent
+// destinations no longer race to overwrite a single shared BNDAddr.
+func (a *udpAssociation) demux(t *udpNATTable, udpLn net.PacketConn) {
+	for {
+		p, err := a.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("stream recv err: %s", err)
+			}
+			break
+		}
+
+		if _, err := udpLn.WriteTo(p.Data, a.clientAddr); err != nil {
+			log.Errorf("conn write err: %s", err)
+			break
+		}
+
+		log.Debugf("udp %q <-- %q", a.clientAddr.String(), a.dstAddr)
+	}
+
+	t.remove(a)
+}
+
+// udpLeakyBuf pools read buffers for the UDP relay socket instead of
+// allocating lib.UDPMaxSize bytes per incoming packet.
+var udpLeakyBuf = lib.NewLeakyBuf(maxNBuf, lib.UDPMaxSize)
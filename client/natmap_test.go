@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNatKey(t *testing.T) {
+	a1 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	a2 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5001}
+
+	if got, want := natKey(a1, "example.com:53"), "10.0.0.1:5000->example.com:53"; got != want {
+		t.Errorf("natKey = %q, want %q", got, want)
+	}
+
+	if natKey(a1, "example.com:53") == natKey(a2, "example.com:53") {
+		t.Error("natKey should differ for distinct client addrs")
+	}
+}
+
+func TestMarkFirstFrameSent(t *testing.T) {
+	a := &udpAssociation{}
+
+	if !a.markFirstFrameSent() {
+		t.Fatal("first call should report true")
+	}
+	if a.markFirstFrameSent() {
+		t.Fatal("second call should report false")
+	}
+}
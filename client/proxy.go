@@ -10,7 +10,10 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"grpc-socks/lib"
 	"grpc-socks/log"
@@ -20,6 +23,11 @@ import (
 const leakyBufSize = 4108 // data.len(2) + hmacsha1(10) + data(4096)
 const maxNBuf = 2048
 
+// socksUserMetadataKey carries the SOCKS5-authenticated username (see
+// lib.Handshake) to the remote side as gRPC call metadata when the stream
+// is opened, so it can audit or apply per-user policy.
+const socksUserMetadataKey = "x-socks-user"
+
 var leakyBuf = lib.NewLeakyBuf(maxNBuf, leakyBufSize)
 
 var callOptions = make([]grpc.CallOption, 0)
@@ -27,7 +35,7 @@ var callOptions = make([]grpc.CallOption, 0)
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	cmd, err := lib.Handshake(conn)
+	cmd, username, err := lib.Handshake(conn)
 	if err != nil {
 		log.Errorf("socks handshake err: %s", err)
 		return
@@ -35,33 +43,22 @@ func handleConnection(conn net.Conn) {
 
 	switch cmd {
 	case lib.CmdConnect:
-		tcpHandler(conn)
+		tcpHandler(conn, username)
 	case lib.CmdUDPAssociate:
-		udpHandler(conn)
+		udpHandler(conn, username)
 	default:
 		log.Errorf("socks cmd %v not supported", cmd)
 		return
 	}
 }
 
-func tcpHandler(conn net.Conn) {
+func tcpHandler(conn net.Conn, username string) {
 	addr, err := lib.GetReqAddr(conn)
 	if err != nil {
 		log.Errorf("get req addr err: %s", err)
 		return
 	}
 
-	// Sending connection established message immediately to client.
-	// This cost some round trip time for creating socks connection with the client.
-	// But if connection failed, the client will get connection reset error.
-	//
-	// Notice that the server response bind addr & port could be ignore by the socks5 client
-	// 0x00 0x00 0x00 0x00 0x00 0x00 is meaning less for bind addr block.
-	_, err = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	if err != nil {
-		return
-	}
-
 	client, err := gRPCClient()
 	if err != nil {
 		log.Errorln(err.Error())
@@ -71,13 +68,30 @@ func tcpHandler(conn net.Conn) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	stream, err := client.Pipeline(ctx, callOptions...)
+	if username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, socksUserMetadataKey, username)
+	}
+
+	stream, err := openPipelineWithRetry(ctx, client)
 	if err != nil {
 		log.Errorf("establish stream err: %s", err)
+		// 0x05 0x04: host unreachable. We haven't told the socks5 client
+		// we succeeded yet, so it gets a clean failure reply instead of a
+		// connection reset.
+		conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
 		return
 	}
 	defer stream.CloseSend()
 
+	// Only now that the upstream stream is actually established do we tell
+	// the socks5 client the connection succeeded.
+	//
+	// Notice that the server response bind addr & port could be ignore by the socks5 client
+	// 0x00 0x00 0x00 0x00 0x00 0x00 is meaning less for bind addr block.
+	if _, err = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		return
+	}
+
 	addrStr := addr.String()
 
 	frame := &pb.Payload{Data: []byte(addrStr)}
@@ -105,6 +119,11 @@ func tcpHandler(conn net.Conn) {
 				if err != io.EOF && ctx.Err() != context.Canceled {
 					log.Errorf("stream recv err: %s", err)
 				}
+				if status.Code(err) == codes.Unavailable {
+					// Keepalive ping on the stream went unanswered; the path
+					// is dead, so tear down the tunnel instead of hanging.
+					cancel()
+				}
 				break
 			}
 
@@ -148,7 +167,7 @@ func tcpHandler(conn net.Conn) {
 	}
 }
 
-func udpHandler(conn net.Conn) {
+func udpHandler(conn net.Conn, username string) {
 	// do not using client indicate add
 	_, err := lib.GetReqAddr(conn)
 	if err != nil {
@@ -165,8 +184,6 @@ func udpHandler(conn net.Conn) {
 	}
 	defer udpLn.Close()
 
-	udpLn.SetReadDeadline(time.Now().Add(time.Second * 600))
-
 	serverBindAddr, err := net.ResolveUDPAddr("udp", udpLn.LocalAddr().String())
 	replay := []byte{0x05, 0x00, 0x00, 0x01} // header of server relpy association
 	rawServerBindAddr := bytes.NewBuffer([]byte{0x0, 0x0, 0x0, 0x0})
@@ -178,62 +195,27 @@ func udpHandler(conn net.Conn) {
 		return
 	}
 
-	client, err := gRPCClient()
-	if err != nil {
-		log.Errorln(err)
-		return
-	}
-
-	stream, err := client.PipelineUDP(context.Background(), callOptions...)
-	if err != nil {
-		log.Errorf("establish stream err: %s", err)
-		return
-	}
-	defer func() {
-		if err = stream.CloseSend(); err != nil {
-			log.Errorf("close stream err: %s", err)
-		}
-	}()
-
-	// natinfo keep the udp nat info for each socks5 association pair
-	type natTableInfo struct {
-		DSTAddr string
-		BNDAddr net.Addr
-	}
-
-	var netInfo = natTableInfo{}
-
-	go func() {
-		for {
-			p, err := stream.Recv()
-			if err == io.EOF {
-				break
-			}
-
-			if err != nil {
-				log.Errorf("stream recv err: %s", err)
-				break
-			}
-
-			_, err = udpLn.WriteTo(p.Data, netInfo.BNDAddr)
-			if err != nil {
-				log.Errorf("conn write err: %s", err)
-				break
-			}
-
-			log.Debugf("udp %q <-- %q", netInfo.BNDAddr.String(), netInfo.DSTAddr)
-		}
-	}()
+	// nat demultiplexes this one relay socket across however many
+	// concurrent (client bind addr, destination) associations the client
+	// opens, each with its own PipelineUDP stream and idle expiry — a
+	// second destination no longer steps on the first's BNDAddr/DSTAddr.
+	nat := newUDPNATTable()
+	defer nat.closeAll()
 
-	buff := make([]byte, lib.UDPMaxSize) // TODO using pool is better
-	first := false                       // TODO need pool to guarantee and first correct?
 	for {
+		// Re-armed every iteration so the relay socket (and this handler's
+		// goroutine, and nat) are torn down once the client goes quiet for
+		// a full idle period, rather than blocking on ReadFrom forever once
+		// every per-destination association has expired.
+		udpLn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+
+		buff := udpLeakyBuf.Get()
 		n, addr, err := udpLn.ReadFrom(buff)
 
 		if n > 0 {
-			netInfo.BNDAddr = addr // TODO may be need cache add add time exp?
+			go func(buff []byte, addr net.Addr, n int) {
+				defer udpLeakyBuf.Put(buff)
 
-			go func(buff []byte) {
 				// 0x00 0x00 for rsv
 				// 0x00 for fragment
 
@@ -246,15 +228,18 @@ func udpHandler(conn net.Conn) {
 				*/
 
 				dst := lib.SplitAddr(buff[3:n])
+				dstAddr := dst.String()
 
-				netInfo.DSTAddr = dst.String()
+				log.Debugf("udp %q --> %q", addr.String(), dstAddr)
 
-				log.Debugf("udp %q --> %q", netInfo.BNDAddr.String(), netInfo.DSTAddr)
+				assoc, err := nat.get(addr, dstAddr, udpLn, username)
+				if err != nil {
+					log.Errorf("establish stream err: %s", err)
+					return
+				}
 
-				if !first {
-					first = true
-					err := stream.Send(&pb.Payload{Data: []byte(netInfo.DSTAddr)})
-					if err != nil {
+				if assoc.markFirstFrameSent() {
+					if err := assoc.stream.Send(&pb.Payload{Data: []byte(dstAddr)}); err != nil {
 						log.Errorf("first frame send err: %s", err)
 						return
 					}
@@ -262,13 +247,12 @@ func udpHandler(conn net.Conn) {
 
 				data := buff[3+len(dst) : n]
 
-				err = stream.Send(&pb.Payload{Data: data})
-				if err != nil {
+				if err := assoc.stream.Send(&pb.Payload{Data: data}); err != nil {
 					log.Errorf("stream send err: %s", err)
-					return
 				}
-			}(buff)
-
+			}(buff, addr, n)
+		} else {
+			udpLeakyBuf.Put(buff)
 		}
 
 		if err != nil {
@@ -276,5 +260,5 @@ func udpHandler(conn net.Conn) {
 		}
 	}
 
-	log.Debugf("closed udp connection to %s", netInfo.DSTAddr)
+	log.Debugf("closed udp relay %q", udpLn.LocalAddr().String())
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig controls the security of the client<->proxy gRPC channel. Leaving
+// it at the zero value with Insecure set is the escape hatch for local/dev
+// use; production deployments should pin CAFile and, for mTLS, CertFile and
+// KeyFile.
+type TLSConfig struct {
+	Insecure   bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string // SNI override; defaults to the dial address's host.
+}
+
+// tlsConfig is populated from the operator's configuration before the first
+// call to gRPCClient().
+var tlsConfig = TLSConfig{Insecure: true}
+
+// transportCredentials builds the credentials.TransportCredentials used to
+// dial the proxy, pinning the configured CA and presenting a client
+// certificate when mTLS is configured.
+func transportCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}
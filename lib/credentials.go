@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FuncCredentialStore adapts a plain function to CredentialStore.
+type FuncCredentialStore func(username, password string) bool
+
+func (f FuncCredentialStore) Authenticate(username, password string) bool {
+	return f(username, password)
+}
+
+// HtpasswdStore authenticates against an htpasswd-style file of
+// "username:bcryptHash" lines, one per user.
+type HtpasswdStore struct {
+	entries map[string]string
+}
+
+// LoadHtpasswd reads an htpasswd-style file into an HtpasswdStore.
+func LoadHtpasswd(path string) (*HtpasswdStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		entries[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &HtpasswdStore{entries: entries}, nil
+}
+
+func (s *HtpasswdStore) Authenticate(username, password string) bool {
+	hash, ok := s.entries[username]
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
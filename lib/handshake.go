@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	Ver5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	userPassVer    = 0x01
+	authStatusOK   = 0x00
+	authStatusFail = 0x01
+)
+
+// CredentialStore verifies a SOCKS5 username/password pair (RFC 1929).
+type CredentialStore interface {
+	Authenticate(username, password string) bool
+}
+
+// Credentials gates the username/password method (0x02) during Handshake.
+// Leaving it nil advertises only the no-auth method, as before.
+var Credentials CredentialStore
+
+// Handshake negotiates the SOCKS5 method with the client and, when
+// Credentials is configured, performs the RFC 1929 username/password
+// sub-negotiation. It returns the requested command and, when
+// username/password auth was used, the authenticated username.
+func Handshake(conn io.ReadWriter) (cmd byte, username string, err error) {
+	method, err := negotiateMethod(conn)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if method == methodUserPass {
+		username, err = authenticateUserPass(conn)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	cmd, err = readRequestCmd(conn)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return cmd, username, nil
+}
+
+func negotiateMethod(conn io.ReadWriter) (byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	if header[0] != Ver5 {
+		return 0, errors.New("unsupported socks version")
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, err
+	}
+
+	selected := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodUserPass && Credentials != nil {
+			selected = methodUserPass
+			break
+		}
+		if m == methodNoAuth && Credentials == nil {
+			selected = methodNoAuth
+		}
+	}
+
+	if _, err := conn.Write([]byte{Ver5, selected}); err != nil {
+		return 0, err
+	}
+	if selected == methodNoAcceptable {
+		return 0, errors.New("no acceptable socks auth method")
+	}
+
+	return selected, nil
+}
+
+// authenticateUserPass performs the RFC 1929 sub-negotiation:
+// VER(1) ULEN(1) UNAME(ULEN) PLEN(1) PASSWD(PLEN).
+func authenticateUserPass(conn io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != userPassVer {
+		return "", errors.New("unsupported username/password sub-negotiation version")
+	}
+
+	uname := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, uname); err != nil {
+		return "", err
+	}
+
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return "", err
+	}
+
+	passwd := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, passwd); err != nil {
+		return "", err
+	}
+
+	username := string(uname)
+	if !Credentials.Authenticate(username, string(passwd)) {
+		conn.Write([]byte{userPassVer, authStatusFail})
+		return "", errors.New("socks auth failed")
+	}
+
+	if _, err := conn.Write([]byte{userPassVer, authStatusOK}); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// readRequestCmd reads VER(1) CMD(1) RSV(1) of the client's request,
+// leaving ATYP and the address for GetReqAddr to parse.
+func readRequestCmd(conn io.ReadWriter) (byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, err
+	}
+	if header[0] != Ver5 {
+		return 0, errors.New("unsupported socks version")
+	}
+	return header[1], nil
+}
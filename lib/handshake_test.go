@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testCredStore struct {
+	user, pass string
+}
+
+func (s testCredStore) Authenticate(username, password string) bool {
+	return username == s.user && password == s.pass
+}
+
+func TestNegotiateMethod(t *testing.T) {
+	cases := []struct {
+		name        string
+		credentials CredentialStore
+		offered     []byte
+		wantMethod  byte
+		wantReply   []byte
+		wantErr     bool
+	}{
+		{
+			name:        "no auth configured picks no-auth",
+			credentials: nil,
+			offered:     []byte{methodNoAuth, methodUserPass},
+			wantMethod:  methodNoAuth,
+			wantReply:   []byte{Ver5, methodNoAuth},
+		},
+		{
+			name:        "auth configured prefers userpass",
+			credentials: testCredStore{"u", "p"},
+			offered:     []byte{methodNoAuth, methodUserPass},
+			wantMethod:  methodUserPass,
+			wantReply:   []byte{Ver5, methodUserPass},
+		},
+		{
+			name:        "auth configured rejects no-auth-only client",
+			credentials: testCredStore{"u", "p"},
+			offered:     []byte{methodNoAuth},
+			wantReply:   []byte{Ver5, methodNoAcceptable},
+			wantErr:     true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			Credentials = c.credentials
+			defer func() { Credentials = nil }()
+
+			req := append([]byte{Ver5, byte(len(c.offered))}, c.offered...)
+			conn := bytes.NewBuffer(req)
+
+			method, err := negotiateMethod(conn)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !c.wantErr && method != c.wantMethod {
+				t.Errorf("method = %#x, want %#x", method, c.wantMethod)
+			}
+			if !bytes.Equal(conn.Bytes(), c.wantReply) {
+				t.Errorf("reply = %#v, want %#v", conn.Bytes(), c.wantReply)
+			}
+		})
+	}
+}
+
+func TestNegotiateMethodBadVersion(t *testing.T) {
+	conn := bytes.NewBuffer([]byte{0x04, 1, methodNoAuth})
+	if _, err := negotiateMethod(conn); err == nil {
+		t.Fatal("expected error for unsupported socks version")
+	}
+}
+
+func TestAuthenticateUserPass(t *testing.T) {
+	Credentials = testCredStore{"alice", "secret"}
+	defer func() { Credentials = nil }()
+
+	t.Run("success", func(t *testing.T) {
+		req := []byte{userPassVer, 5, 'a', 'l', 'i', 'c', 'e', 6, 's', 'e', 'c', 'r', 'e', 't'}
+		conn := bytes.NewBuffer(req)
+
+		username, err := authenticateUserPass(conn)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if username != "alice" {
+			t.Errorf("username = %q, want %q", username, "alice")
+		}
+		if want := []byte{userPassVer, authStatusOK}; !bytes.Equal(conn.Bytes(), want) {
+			t.Errorf("reply = %#v, want %#v", conn.Bytes(), want)
+		}
+	})
+
+	t.Run("bad credentials", func(t *testing.T) {
+		req := []byte{userPassVer, 5, 'a', 'l', 'i', 'c', 'e', 5, 'w', 'r', 'o', 'n', 'g'}
+		conn := bytes.NewBuffer(req)
+
+		if _, err := authenticateUserPass(conn); err == nil {
+			t.Fatal("expected error for bad credentials")
+		}
+		if want := []byte{userPassVer, authStatusFail}; !bytes.Equal(conn.Bytes(), want) {
+			t.Errorf("reply = %#v, want %#v", conn.Bytes(), want)
+		}
+	})
+
+	t.Run("bad version", func(t *testing.T) {
+		conn := bytes.NewBuffer([]byte{0x02, 0})
+		if _, err := authenticateUserPass(conn); err == nil {
+			t.Fatal("expected error for unsupported sub-negotiation version")
+		}
+	})
+}
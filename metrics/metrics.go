@@ -0,0 +1,125 @@
+// Package metrics wires gRPC's stats.Handler lifecycle into Prometheus
+// metrics and OpenTelemetry spans, shared by the client dialer and the
+// proxy server so both sides of a Pipeline/PipelineUDP tunnel report the
+// same bytes-in/out, duration, and status.
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	BytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "socks_tunnel_bytes_total",
+		Help: "Bytes transferred over Pipeline/PipelineUDP tunnels.",
+	}, []string{"direction", "proto"})
+
+	TunnelDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "socks_tunnel_duration_seconds",
+		Help: "Duration of a Pipeline/PipelineUDP tunnel from open to close.",
+	}, []string{"proto", "code"})
+
+	ActiveStreams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "socks_active_streams",
+		Help: "Currently open Pipeline/PipelineUDP streams.",
+	}, []string{"proto"})
+)
+
+var tracer = otel.Tracer("grpc-socks")
+
+// protoFromMethod derives the tunnel kind ("tcp" for Pipeline, "udp" for
+// PipelineUDP) from the RPC's full method name. Unlike a context.Value set
+// by the dialer, this works identically on both the client and the server
+// side of the call, since the method name travels with the RPC itself.
+func protoFromMethod(fullMethod string) string {
+	switch {
+	case strings.HasSuffix(fullMethod, "PipelineUDP"):
+		return "udp"
+	case strings.HasSuffix(fullMethod, "Pipeline"):
+		return "tcp"
+	default:
+		return "unknown"
+	}
+}
+
+type connData struct {
+	proto string
+}
+
+type connDataKeyType struct{}
+
+var connDataKey connDataKeyType
+
+// Handler implements grpc/stats.Handler, recording Prometheus counters and
+// an OpenTelemetry span per Pipeline/PipelineUDP RPC.
+type Handler struct {
+	// OnEnd, if set, runs when an RPC ends — regardless of whether it ran
+	// to completion or was cut short by an interceptor (e.g. rejected by
+	// an auth check after tap-time state was already reserved for it).
+	// This is the only lifecycle point guaranteed to fire once per RPC
+	// that actually got past grpc.InTapHandle, so the server uses it to
+	// release policy state reserved in Policy.TapHandle.
+	OnEnd func(ctx context.Context)
+}
+
+func (h Handler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	proto := protoFromMethod(info.FullMethodName)
+
+	ctx, span := tracer.Start(ctx, info.FullMethodName)
+	span.SetAttributes(attribute.String("socks.proto", proto))
+	if p, ok := peer.FromContext(ctx); ok {
+		span.SetAttributes(attribute.String("socks.peer", p.Addr.String()))
+	}
+
+	return context.WithValue(ctx, connDataKey, &connData{proto: proto})
+}
+
+func (h Handler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	cd, _ := ctx.Value(connDataKey).(*connData)
+	proto := "unknown"
+	if cd != nil {
+		proto = cd.proto
+	}
+
+	switch e := s.(type) {
+	case *stats.Begin:
+		ActiveStreams.WithLabelValues(proto).Inc()
+	case *stats.InPayload:
+		BytesTotal.WithLabelValues("in", proto).Add(float64(e.Length))
+	case *stats.OutPayload:
+		BytesTotal.WithLabelValues("out", proto).Add(float64(e.Length))
+	case *stats.End:
+		ActiveStreams.WithLabelValues(proto).Dec()
+
+		code := status.Code(e.Error)
+		duration := e.EndTime.Sub(e.BeginTime)
+		TunnelDuration.WithLabelValues(proto, code.String()).Observe(duration.Seconds())
+
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.String("socks.grpc_code", code.String()))
+		if e.Error != nil {
+			span.RecordError(e.Error)
+		}
+		span.End()
+
+		if h.OnEnd != nil {
+			h.OnEnd(ctx)
+		}
+	}
+}
+
+func (Handler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (Handler) HandleConn(ctx context.Context, s stats.ConnStats) {}
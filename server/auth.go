@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenMetadataKey mirrors the client's auth metadata key.
+const tokenMetadataKey = "authorization"
+
+// TokenValidator checks a bearer token extracted from call metadata.
+// Implementations report whether the token is currently valid.
+type TokenValidator interface {
+	Valid(token string) bool
+}
+
+// tokenValidator validates the per-call auth token. Leaving it nil disables
+// token auth (e.g. when relying on mTLS alone).
+var tokenValidator TokenValidator
+
+func tokenFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	vals := md.Get(tokenMetadataKey)
+	if len(vals) == 0 {
+		return "", false
+	}
+	return strings.TrimPrefix(vals[0], "Bearer "), true
+}
+
+func authenticate(ctx context.Context) error {
+	if tokenValidator == nil {
+		return nil
+	}
+	token, ok := tokenFromContext(ctx)
+	if !ok || !tokenValidator.Valid(token) {
+		return status.Error(codes.Unauthenticated, "invalid or missing auth token")
+	}
+	return nil
+}
+
+func authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authenticate(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
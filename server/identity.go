@@ -0,0 +1,25 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// socksUserMetadataKey mirrors the client's metadata key carrying the
+// SOCKS5-authenticated username as call metadata on Pipeline/PipelineUDP.
+const socksUserMetadataKey = "x-socks-user"
+
+// SocksUserFromContext returns the SOCKS5-authenticated username forwarded
+// by the client, if any, for audit logging or per-user policy.
+func SocksUserFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	vals := md.Get(socksUserMetadataKey)
+	if len(vals) == 0 {
+		return "", false
+	}
+	return vals[0], true
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/tap"
+)
+
+// limiterIdleTTL bounds how long a per-source rate limiter is kept once
+// that source goes quiet, so Policy.limiters doesn't grow without bound
+// over the life of the process.
+const limiterIdleTTL = 10 * time.Minute
+
+// limiterSweepInterval throttles how often limiterFor scans for idle
+// entries to evict.
+const limiterSweepInterval = time.Minute
+
+// ACL matches a Pipeline/PipelineUDP destination against CIDR, port, and
+// hostname rules. A destination must pass every configured check; nil
+// slices/patterns mean "no restriction" on that dimension.
+type ACL struct {
+	AllowCIDRs      []*net.IPNet
+	DenyCIDRs       []*net.IPNet
+	AllowPorts      map[int]bool
+	DenyPorts       map[int]bool
+	HostnamePattern *regexp.Regexp
+}
+
+// Allowed reports whether host:port may be dialed. host may be an IP or a
+// hostname; IP-based rules are skipped for hostnames and vice versa. Either
+// kind of destination is deliberately rejected when its own kind has no
+// allow rule but the *other* kind has rules configured: an IP literal when
+// HostnamePattern is set and AllowCIDRs is empty, or a hostname when
+// HostnamePattern is unset and any CIDR rule is set. Otherwise a client could
+// bypass the configured rule by resolving the name itself (or vice versa)
+// and issuing the request with the other ATYP.
+func (a *ACL) Allowed(host string, port int) bool {
+	if a == nil {
+		return true
+	}
+
+	if a.DenyPorts[port] {
+		return false
+	}
+	if len(a.AllowPorts) > 0 && !a.AllowPorts[port] {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range a.DenyCIDRs {
+			if n.Contains(ip) {
+				return false
+			}
+		}
+		if len(a.AllowCIDRs) > 0 {
+			allowed := false
+			for _, n := range a.AllowCIDRs {
+				if n.Contains(ip) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return false
+			}
+		} else if a.HostnamePattern != nil {
+			return false
+		}
+	} else if a.HostnamePattern != nil {
+		if !a.HostnamePattern.MatchString(host) {
+			return false
+		}
+	} else if len(a.AllowCIDRs) > 0 || len(a.DenyCIDRs) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// Policy enforces connection concurrency limits, per-source rate limits,
+// and a destination ACL on incoming Pipeline/PipelineUDP RPCs.
+type Policy struct {
+	MaxGlobalConns  int
+	MaxPerUserConns int
+	RatePerSecond   float64
+	RateBurst       int
+	ACL             *ACL
+
+	mu          sync.Mutex
+	globalConns int
+	userConns   map[string]int
+	limiters    map[string]*limiterEntry
+	lastSweep   time.Time
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewPolicy() *Policy {
+	return &Policy{
+		userConns: make(map[string]int),
+		limiters:  make(map[string]*limiterEntry),
+	}
+}
+
+// limiterFor returns the token bucket for source (an IP, not host:port —
+// callers should strip the ephemeral port first, since a fresh gRPC dial
+// per SOCKS5 connection means the port changes on nearly every call). It
+// also opportunistically evicts limiters idle for longer than
+// limiterIdleTTL.
+func (p *Policy) limiterFor(source string) *rate.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.evictIdleLimitersLocked(now)
+
+	e, ok := p.limiters[source]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(p.RatePerSecond), p.RateBurst)}
+		p.limiters[source] = e
+	}
+	e.lastSeen = now
+	return e.limiter
+}
+
+func (p *Policy) evictIdleLimitersLocked(now time.Time) {
+	if now.Sub(p.lastSweep) < limiterSweepInterval {
+		return
+	}
+	p.lastSweep = now
+
+	for source, e := range p.limiters {
+		if now.Sub(e.lastSeen) > limiterIdleTTL {
+			delete(p.limiters, source)
+		}
+	}
+}
+
+// TapHandle implements grpc.InTapHandle: it runs for every incoming
+// Pipeline/PipelineUDP RPC before any goroutine or stream state is
+// allocated, so rejections here are cheap. The RPC's destination isn't
+// known yet at this point (it arrives as the first frame on the stream),
+// so ACL matching happens in CheckDestination once the stream handler
+// reads that frame; this only enforces concurrency and per-source rate
+// limits.
+func (p *Policy) TapHandle(ctx context.Context, info *tap.Info) (context.Context, error) {
+	source := "unknown"
+	if pr, ok := peer.FromContext(ctx); ok {
+		source = sourceIP(pr.Addr)
+	}
+
+	if p.RatePerSecond > 0 && !p.limiterFor(source).Allow() {
+		return ctx, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	user, _ := SocksUserFromContext(ctx)
+	if err := p.reserveConn(user); err != nil {
+		return ctx, err
+	}
+
+	return ctx, nil
+}
+
+// sourceIP strips the ephemeral port off addr, so a rate limiter is keyed
+// by the client's IP rather than by a (addr, port) pair that changes on
+// every dial.
+func sourceIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (p *Policy) reserveConn(user string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.MaxGlobalConns > 0 && p.globalConns >= p.MaxGlobalConns {
+		return status.Error(codes.ResourceExhausted, "global connection limit reached")
+	}
+	if user != "" && p.MaxPerUserConns > 0 && p.userConns[user] >= p.MaxPerUserConns {
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("connection limit reached for user %q", user))
+	}
+
+	p.globalConns++
+	if user != "" {
+		p.userConns[user]++
+	}
+	return nil
+}
+
+// Release gives back the connection slot reserved by TapHandle for user.
+// NewServer wires this into the metrics.Handler's OnEnd hook so it fires
+// once per RPC regardless of whether the RPC ran to completion or was
+// rejected by an interceptor after TapHandle had already reserved it.
+func (p *Policy) Release(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.globalConns > 0 {
+		p.globalConns--
+	}
+	if user != "" && p.userConns[user] > 0 {
+		p.userConns[user]--
+	}
+}
+
+// CheckDestination matches addr ("host:port") against the policy's ACL.
+// Call this from the Pipeline/PipelineUDP handler once the first frame
+// carrying the destination has been read off the stream.
+func (p *Policy) CheckDestination(addr string) error {
+	if p == nil || p.ACL == nil {
+		return nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "malformed destination %q", addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "malformed destination port %q", portStr)
+	}
+
+	if !p.ACL.Allowed(host, port) {
+		return status.Errorf(codes.PermissionDenied, "destination %q denied by policy", addr)
+	}
+	return nil
+}
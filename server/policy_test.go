@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net"
+	"regexp"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", s, err)
+	}
+	return n
+}
+
+func TestACLAllowedNilACL(t *testing.T) {
+	var a *ACL
+	if !a.Allowed("example.com", 443) {
+		t.Error("nil ACL should allow everything")
+	}
+}
+
+func TestACLAllowedPorts(t *testing.T) {
+	a := &ACL{
+		AllowPorts: map[int]bool{80: true, 443: true},
+		DenyPorts:  map[int]bool{25: true},
+	}
+
+	if !a.Allowed("10.0.0.1", 443) {
+		t.Error("expected allowed port to pass")
+	}
+	if a.Allowed("10.0.0.1", 8080) {
+		t.Error("port not in AllowPorts should be denied")
+	}
+	if (&ACL{DenyPorts: a.DenyPorts}).Allowed("10.0.0.1", 25) {
+		t.Error("port in DenyPorts should be denied even without AllowPorts")
+	}
+}
+
+func TestACLAllowedCIDRs(t *testing.T) {
+	a := &ACL{
+		AllowCIDRs: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")},
+		DenyCIDRs:  []*net.IPNet{mustCIDR(t, "10.1.0.0/16")},
+	}
+
+	if !a.Allowed("10.0.0.1", 443) {
+		t.Error("IP inside AllowCIDRs should pass")
+	}
+	if a.Allowed("10.1.0.1", 443) {
+		t.Error("IP inside DenyCIDRs should be denied even though also in AllowCIDRs")
+	}
+	if a.Allowed("192.168.1.1", 443) {
+		t.Error("IP outside AllowCIDRs should be denied")
+	}
+}
+
+func TestACLAllowedIPAgainstHostnameOnlyACL(t *testing.T) {
+	a := &ACL{HostnamePattern: regexp.MustCompile(`\.internal$`)}
+
+	if a.Allowed("10.0.0.1", 443) {
+		t.Error("IP literal should be denied by a hostname-only ACL")
+	}
+	if !a.Allowed("foo.internal", 443) {
+		t.Error("matching hostname should be allowed")
+	}
+	if a.Allowed("foo.external", 443) {
+		t.Error("non-matching hostname should be denied")
+	}
+}
+
+func TestACLAllowedHostnameAgainstCIDROnlyACL(t *testing.T) {
+	a := &ACL{AllowCIDRs: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}}
+
+	if a.Allowed("example.com", 443) {
+		t.Error("hostname should be denied by an IP-only (CIDR) ACL")
+	}
+	if !a.Allowed("10.0.0.1", 443) {
+		t.Error("IP matching AllowCIDRs should still be allowed")
+	}
+}
+
+func TestACLAllowedNoRulesConfigured(t *testing.T) {
+	a := &ACL{}
+
+	if !a.Allowed("example.com", 443) {
+		t.Error("hostname should pass when no CIDR/hostname rules are configured")
+	}
+	if !a.Allowed("10.0.0.1", 443) {
+		t.Error("IP should pass when no CIDR/hostname rules are configured")
+	}
+}
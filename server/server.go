@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	"grpc-socks/metrics"
+)
+
+// keepaliveServerParams mirrors the client's keepalive.ClientParameters so a
+// client sitting idle behind a dead NAT/firewall path gets dropped instead of
+// pinning a goroutine and a stream forever.
+var keepaliveServerParams = keepalive.ServerParameters{
+	Time:    20 * time.Second,
+	Timeout: 10 * time.Second,
+}
+
+// keepaliveEnforcement rejects pings sent more often than every 15s from
+// misbehaving or malicious clients, per the grpc-go keepalive guidance.
+var keepaliveEnforcement = keepalive.EnforcementPolicy{
+	MinTime:             15 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// NewServer builds the gRPC server used to accept Pipeline/PipelineUDP
+// streams, with keepalive tuned to detect dead SOCKS tunnels promptly,
+// transport security applied per tlsCfg, and policy (may be nil to disable
+// concurrency/rate/ACL enforcement) hooked in via grpc.InTapHandle.
+func NewServer(tlsCfg TLSConfig, policy *Policy, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	statsHandler := metrics.Handler{}
+	if policy != nil {
+		// TapHandle reserves a connection slot before the auth
+		// interceptors run, so an RPC they reject never reaches the
+		// Pipeline/PipelineUDP handler that would otherwise release it.
+		// stats.End fires for every RPC that got past TapHandle
+		// regardless of where it was cut short, so release there instead.
+		statsHandler.OnEnd = func(ctx context.Context) {
+			user, _ := SocksUserFromContext(ctx)
+			policy.Release(user)
+		}
+	}
+
+	opts = append(opts,
+		grpc.KeepaliveParams(keepaliveServerParams),
+		grpc.KeepaliveEnforcementPolicy(keepaliveEnforcement),
+		grpc.ChainUnaryInterceptor(authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(authStreamInterceptor),
+		grpc.StatsHandler(statsHandler),
+	)
+
+	if policy != nil {
+		opts = append(opts, grpc.InTapHandle(policy.TapHandle))
+	}
+
+	creds, err := transportCredentials(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	return grpc.NewServer(opts...), nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig controls the security of the gRPC listener. The zero value
+// (no CertFile/KeyFile configured) is the escape hatch for local/dev use
+// and is treated as insecure the same as explicitly setting Insecure;
+// production deployments should set CertFile/KeyFile and, to require
+// client certs (mTLS), CAFile.
+type TLSConfig struct {
+	Insecure bool
+	CertFile string
+	KeyFile  string
+	CAFile   string // client CA; when set, client certs are required.
+}
+
+// transportCredentials builds the credentials.TransportCredentials the
+// server presents to connecting clients, requiring and verifying a client
+// certificate when CAFile is configured (mTLS).
+func transportCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure || (cfg.CertFile == "" && cfg.KeyFile == "") {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server cert: %w", err)
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}